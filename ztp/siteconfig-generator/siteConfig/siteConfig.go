@@ -0,0 +1,39 @@
+package siteConfig
+
+// CPUPartitioning controls how a cluster's CPUPartitioning install-config
+// field is set.
+type CPUPartitioning string
+
+const (
+	CPUPartitioningAllNodes CPUPartitioning = "AllNodes"
+)
+
+const (
+	ZtpAnnotation                          = "ran.openshift.io/ztp-gitops-generated"
+	ZtpAnnotationDefaultValue              = "{}"
+	ZtpWarningAnnotation                   = "ran.openshift.io/ztp-deprecation-warning"
+	ZtpDeprecationWarningAnnotationPostfix = "generated"
+
+	nodeLabelPrefix         = "bmac.agent-install.openshift.io.node-label"
+	inspectAnnotationPrefix = "inspect.metal3.io"
+	inspectDisabled         = "disabled"
+)
+
+// NodeSpec describes one bare-metal host within a Clusters entry.
+type NodeSpec struct {
+	Cpuset string `json:"cpuset,omitempty" yaml:"cpuset,omitempty"`
+}
+
+// Clusters is the siteconfig representation of a single spoke cluster
+// definition. Only the fields read by siteConfigHelper.go are declared
+// here; the remaining cluster-definition fields live alongside the rest of
+// the siteconfig-generator manifest-generation code.
+type Clusters struct {
+	Nodes []NodeSpec `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+
+	ExtraManifestPath      string            `json:"extraManifestPath,omitempty" yaml:"extraManifestPath,omitempty"`
+	InstallConfigOverrides string            `json:"installConfigOverrides,omitempty" yaml:"installConfigOverrides,omitempty"`
+	CPUPartitioning        CPUPartitioning   `json:"cpuPartitioningMode,omitempty" yaml:"cpuPartitioningMode,omitempty"`
+	OSImageURL             string            `json:"osImageURL,omitempty" yaml:"osImageURL,omitempty"`
+	OSImageURLOverrides    map[string]string `json:"osImageURLOverrides,omitempty" yaml:"osImageURLOverrides,omitempty"`
+}
@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"reflect"
 	"strings"
 	"sync"
 
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/google/cel-go/cel"
 	machineconfigv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	mcfgctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 
 	"github.com/lack/yamltrim"
 	yaml "gopkg.in/yaml.v3"
@@ -17,6 +22,25 @@ import (
 const McName = "predefined-extra-manifests"
 const mcKind = "MachineConfig"
 
+// DeprecationWarningsEnvVar names the environment variable that, if set,
+// points at a YAML file of deprecation/warning registry entries to load in
+// place of the built-in annotationMessages. See LoadAnnotationMessages.
+const DeprecationWarningsEnvVar = "ZTP_DEPRECATION_WARNINGS_FILE"
+
+// defaultDeprecationWarningsFile is the well-known absolute path checked
+// when DeprecationWarningsEnvVar is unset.
+const defaultDeprecationWarningsFile = "/usr/local/share/siteconfig/deprecation-warnings.yaml"
+
+// Severity controls how a deprecation/warning registry entry should be
+// treated once it fires: SeverityWarning is purely advisory, SeverityError
+// lets HasErrors callers upgrade it to a hard validation failure.
+type Severity string
+
+const (
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
 // annotationWarning is a helper to create warning annotation
 // The `values` field should contain a key for the specific CR you wish to apply a warning to
 // and the struct will associated to that CR key will simply contain the specific thing you wish to warn about
@@ -30,6 +54,7 @@ type annotationWarning struct {
 type annotationValue struct {
 	fieldName    string
 	fieldMessage string
+	severity     Severity
 }
 
 type AnnotationMessage struct {
@@ -44,6 +69,7 @@ var annotationMessages = []AnnotationMessage{
 		annotationValue: annotationValue{
 			fieldName:    "cpuset",
 			fieldMessage: "cpuset will be deprecated after OCP 4.15, please use cpuPartitioningMode for OCP versions >= 4.14",
+			severity:     SeverityWarning,
 		},
 		ShouldBeApplied: func(c Clusters) bool {
 			for _, node := range c.Nodes {
@@ -60,6 +86,7 @@ var annotationMessages = []AnnotationMessage{
 		annotationValue: annotationValue{
 			fieldName:    "extraManifestPath",
 			fieldMessage: "extraManifestPath will be deprecated after OCP 4.15, please use ExtraManifests.SearchPaths for OCP versions >= 4.14",
+			severity:     SeverityWarning,
 		},
 		ShouldBeApplied: func(c Clusters) bool {
 			if len(c.ExtraManifestPath) > 0 {
@@ -70,6 +97,186 @@ var annotationMessages = []AnnotationMessage{
 	},
 }
 
+// registryEntry is the on-disk YAML shape for a single deprecation/warning
+// rule loaded by LoadAnnotationMessages. When is a CEL expression evaluated
+// against a `cluster` variable (a map view of Clusters) and, for
+// node-scoped checks, a `node` variable (a map view of a single Node); it
+// must evaluate to a bool.
+type registryEntry struct {
+	CRName    string   `yaml:"crName"`
+	FieldName string   `yaml:"fieldName"`
+	Message   string   `yaml:"message"`
+	Severity  Severity `yaml:"severity"`
+	When      string   `yaml:"when"`
+}
+
+var (
+	celEnv       *cel.Env
+	celEnvOnce   sync.Once
+	celEnvErr    error
+	celProgCache sync.Map // expression text -> cel.Program
+)
+
+// getCelEnv lazily builds the single CEL environment used to compile every
+// registry "when" expression, declaring the `cluster` and `node` variables
+// expressions are evaluated against.
+func getCelEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = cel.NewEnv(
+			cel.Variable("cluster", cel.DynType),
+			cel.Variable("node", cel.DynType),
+		)
+	})
+	return celEnv, celEnvErr
+}
+
+// compileCelExpression compiles expr once and caches the resulting
+// cel.Program keyed by the expression text, so repeated loads (or repeated
+// entries sharing an expression) don't pay compilation cost twice. It
+// rejects expressions that don't evaluate to a bool.
+func compileCelExpression(expr string) (cel.Program, error) {
+	if cached, ok := celProgCache.Load(expr); ok {
+		return cached.(cel.Program), nil
+	}
+
+	env, err := getCelEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression %q must evaluate to a bool, got %s", expr, ast.OutputType())
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	celProgCache.Store(expr, prg)
+	return prg, nil
+}
+
+// toCelMap converts v (a Clusters or NodeSpec) to a map[string]interface{}
+// via its JSON representation, giving CEL expressions a plain map view of
+// the struct's exported fields.
+func toCelMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// evaluateCelCondition runs prg once against a cluster-scoped view of c, and
+// once per node against a node-scoped view, returning true if any
+// evaluation returns true. Evaluation errors are swallowed here because
+// type errors are already caught by compileCelExpression at load time.
+func evaluateCelCondition(prg cel.Program, c Clusters) bool {
+	clusterView, err := toCelMap(c)
+	if err != nil {
+		return false
+	}
+
+	if out, _, err := prg.Eval(map[string]interface{}{"cluster": clusterView, "node": nil}); err == nil {
+		if result, ok := out.Value().(bool); ok && result {
+			return true
+		}
+	}
+
+	for _, node := range c.Nodes {
+		nodeView, err := toCelMap(node)
+		if err != nil {
+			continue
+		}
+		out, _, err := prg.Eval(map[string]interface{}{"cluster": clusterView, "node": nodeView})
+		if err != nil {
+			continue
+		}
+		if result, ok := out.Value().(bool); ok && result {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadAnnotationMessages returns the deprecation/warning registry entries to
+// evaluate against each cluster. If path is empty, DeprecationWarningsEnvVar
+// is checked, then defaultDeprecationWarningsFile. If none of those name a
+// file that exists, the built-in annotationMessages are returned unchanged.
+// Every "when" expression is compiled up front so a malformed expression is
+// reported as a load error, with the file path and the source line of the
+// offending entry, rather than failing silently at evaluation time.
+func LoadAnnotationMessages(path string) ([]AnnotationMessage, error) {
+	if path == "" {
+		path = os.Getenv(DeprecationWarningsEnvVar)
+	}
+	if path == "" {
+		path = defaultDeprecationWarningsFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return annotationMessages, nil
+		}
+		return nil, fmt.Errorf("could not read deprecation warnings file %s: %v", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse deprecation warnings file %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return []AnnotationMessage{}, nil
+	}
+	// doc is the implicit document node; its single child is the top-level
+	// sequence, each of whose items is one registry entry mapping node. Going
+	// through yaml.Node rather than unmarshalling directly into
+	// []registryEntry keeps each entry's Line so a malformed "when" can be
+	// pinpointed in the source file instead of just an entry index.
+	entryNodes := doc.Content[0].Content
+
+	messages := make([]AnnotationMessage, 0, len(entryNodes))
+	for _, node := range entryNodes {
+		var entry registryEntry
+		if err := node.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: could not parse registry entry: %v", path, node.Line, err)
+		}
+		if entry.Severity == "" {
+			entry.Severity = SeverityWarning
+		}
+
+		prg, err := compileCelExpression(entry.When)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s/%s: invalid \"when\" expression: %v", path, node.Line, entry.CRName, entry.FieldName, err)
+		}
+
+		messages = append(messages, AnnotationMessage{
+			CRName: entry.CRName,
+			annotationValue: annotationValue{
+				fieldName:    entry.FieldName,
+				fieldMessage: entry.Message,
+				severity:     entry.Severity,
+			},
+			ShouldBeApplied: func(c Clusters) bool {
+				return evaluateCelCondition(prg, c)
+			},
+		})
+	}
+
+	return messages, nil
+}
+
 func NewAnnotationWarning(annoKey string) *annotationWarning {
 	return &annotationWarning{
 		annoKey: fmt.Sprintf("%s-%s", ZtpWarningAnnotation, annoKey),
@@ -82,11 +289,11 @@ func (d *annotationWarning) init() {
 	}
 }
 
-func (d *annotationWarning) Add(crName, field, message string) {
+func (d *annotationWarning) Add(crName, field, message string, severity Severity) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	d.init()
-	d.values[crName] = append(d.values[crName], annotationValue{fieldName: field, fieldMessage: message})
+	d.values[crName] = append(d.values[crName], annotationValue{fieldName: field, fieldMessage: message, severity: severity})
 }
 
 func (d *annotationWarning) GetAnnotationKey(val annotationValue) string {
@@ -105,13 +312,357 @@ func (d *annotationWarning) HasWarnings() bool {
 	return len(d.values) > 0
 }
 
-// merge the spec fields of all MC manifests except the ones that are in the doNotMerge list
-func MergeManifests(individualMachineConfigs map[string]interface{}, doNotMerge map[string]bool) (map[string]interface{}, error) {
+// HasErrors reports whether any recorded value was added with SeverityError,
+// letting callers upgrade what would otherwise be an informational
+// annotation into a hard validation failure.
+func (d *annotationWarning) HasErrors() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for _, values := range d.values {
+		for _, v := range values {
+			if v.severity == SeverityError {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrorMessages returns the fieldMessage of every recorded value with
+// SeverityError, for surfacing as a hard validation failure wherever
+// HasErrors is checked.
+func (d *annotationWarning) ErrorMessages() []string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	var messages []string
+	for _, values := range d.values {
+		for _, v := range values {
+			if v.severity == SeverityError {
+				messages = append(messages, v.fieldMessage)
+			}
+		}
+	}
+	return messages
+}
+
+// strategicMergePatchSuffix and jsonMergePatchSuffix name the two kinds of
+// per-role patch fragment MergeManifests recognizes, named "<role>.smp.yaml"
+// / "<role>.jmp.yaml": a filename ending in strategicMergePatchSuffix is
+// applied with strategicpatch.StrategicMergePatch, one ending in
+// jsonMergePatchSuffix with jsonpatch.MergePatch.
+const (
+	strategicMergePatchSuffix = ".smp.yaml"
+	jsonMergePatchSuffix      = ".jmp.yaml"
+)
+
+// rolePatch is a single strategic-merge-patch or JSON-merge-patch fragment
+// destined for one MachineConfig role.
+type rolePatch struct {
+	strategic bool
+	json      []byte
+}
+
+func isPatchFile(filename string) bool {
+	return strings.HasSuffix(filename, strategicMergePatchSuffix) || strings.HasSuffix(filename, jsonMergePatchSuffix)
+}
+
+// roleFromPatchFilename extracts the target role out of a patch filename of
+// the form "<role>.smp.yaml" or "<role>.jmp.yaml". The role is read from the
+// filename, not from the patch body, since a JSON-merge-patch fragment is
+// typically just the subset of fields being overridden (e.g.
+// {"spec":{"kernelArguments":[...]}}) with no metadata.labels of its own.
+func roleFromPatchFilename(filename string) string {
+	role := strings.TrimSuffix(filename, strategicMergePatchSuffix)
+	return strings.TrimSuffix(role, jsonMergePatchSuffix)
+}
+
+// collectRolePatches pulls every *.smp.yaml / *.jmp.yaml patch fragment out
+// of individualMachineConfigs, removing it from the map, and groups the
+// resulting patches by the role named in the patch filename.
+func collectRolePatches(individualMachineConfigs map[string]interface{}) (map[string][]rolePatch, error) {
+	patchesByRole := map[string][]rolePatch{}
+
+	for filename, content := range individualMachineConfigs {
+		if !isPatchFile(filename) {
+			continue
+		}
+
+		var data map[string]interface{}
+		if err := yaml.Unmarshal([]byte(content.(string)), &data); err != nil {
+			return nil, fmt.Errorf("could not unmarshal patch file (%s): %v", filename, err)
+		}
+
+		patchJSON, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert patch file (%s) to json: %v", filename, err)
+		}
+
+		role := roleFromPatchFilename(filename)
+		patchesByRole[role] = append(patchesByRole[role], rolePatch{
+			strategic: strings.HasSuffix(filename, strategicMergePatchSuffix),
+			json:      patchJSON,
+		})
+
+		delete(individualMachineConfigs, filename)
+	}
+
+	return patchesByRole, nil
+}
+
+// applyRolePatches applies, in order, every patch destined for a role to
+// mcJSON (the JSON encoding of that role's MachineConfig), returning the
+// patched JSON.
+func applyRolePatches(mcJSON []byte, patches []rolePatch) ([]byte, error) {
+	for _, patch := range patches {
+		var (
+			patched []byte
+			err     error
+		)
+		if patch.strategic {
+			patched, err = applyStrategicMCPatch(mcJSON, patch.json)
+		} else {
+			patched, err = jsonpatch.MergePatch(mcJSON, patch.json)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not apply patch: %v", err)
+		}
+		mcJSON = patched
+	}
+	return mcJSON, nil
+}
+
+// ignitionListMergeKeys names the field under every ignition config list
+// that uniquely identifies one of its entries, for the list fields ignition
+// configs commonly carry.
+var ignitionListMergeKeys = map[string]string{
+	"files":       "path",
+	"directories": "path",
+	"links":       "path",
+	"units":       "name",
+	"users":       "name",
+	"groups":      "name",
+}
+
+// applyStrategicMCPatch applies a strategic-merge-patch fragment against
+// mcJSON using the machineconfigv1.MachineConfig schema. spec.config is a
+// runtime.RawExtension, so strategicpatch has no patchMergeKey metadata for
+// anything nested inside it (e.g. spec.config.storage.files) and would
+// replace those lists wholesale instead of merging them by key; the
+// ignition config is therefore merged explicitly and spliced back into the
+// strategic-merge-patch result.
+func applyStrategicMCPatch(mcJSON, patchJSON []byte) ([]byte, error) {
+	patched, err := strategicpatch.StrategicMergePatch(mcJSON, patchJSON, &machineconfigv1.MachineConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	baseConfig, baseFound, err := ignitionConfigFromMCJSON(mcJSON)
+	if err != nil {
+		return nil, err
+	}
+	patchConfig, patchFound, err := ignitionConfigFromMCJSON(patchJSON)
+	if err != nil {
+		return nil, err
+	}
+	if !baseFound || !patchFound {
+		return patched, nil
+	}
+
+	var patchedMC map[string]interface{}
+	if err := json.Unmarshal(patched, &patchedMC); err != nil {
+		return nil, fmt.Errorf("could not convert patched mc to map: %v", err)
+	}
+	spec, ok := patchedMC["spec"].(map[string]interface{})
+	if !ok {
+		spec = map[string]interface{}{}
+		patchedMC["spec"] = spec
+	}
+	spec["config"] = mergeIgnitionConfig(baseConfig, patchConfig)
+
+	return json.Marshal(patchedMC)
+}
+
+// ignitionConfigFromMCJSON extracts spec.config from a JSON-encoded
+// MachineConfig, returning found=false if mcJSON has no such object.
+func ignitionConfigFromMCJSON(mcJSON []byte) (config map[string]interface{}, found bool, err error) {
+	var mc map[string]interface{}
+	if err := json.Unmarshal(mcJSON, &mc); err != nil {
+		return nil, false, fmt.Errorf("could not unmarshal mc json: %v", err)
+	}
+	spec, ok := mc["spec"].(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	config, ok = spec["config"].(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	return config, true, nil
+}
+
+// mergeIgnitionConfig recursively merges a patch ignition config into a
+// base ignition config: object fields recurse, the known ignition list
+// fields (storage.files/directories/links, systemd.units, passwd.users/
+// groups) merge by their natural key via mergeIgnitionList, and everything
+// else is replaced wholesale by the patch value, matching ignition's own
+// merge semantics for non-keyed fields.
+func mergeIgnitionConfig(base, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(patch))
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, patchVal := range patch {
+		baseVal, found := merged[k]
+		if !found {
+			merged[k] = patchVal
+			continue
+		}
+
+		if baseObj, ok := baseVal.(map[string]interface{}); ok {
+			if patchObj, ok := patchVal.(map[string]interface{}); ok {
+				merged[k] = mergeIgnitionConfig(baseObj, patchObj)
+				continue
+			}
+		}
+
+		if mergeKey, known := ignitionListMergeKeys[k]; known {
+			if baseArr, ok := baseVal.([]interface{}); ok {
+				if patchArr, ok := patchVal.([]interface{}); ok {
+					merged[k] = mergeIgnitionList(baseArr, patchArr, mergeKey)
+					continue
+				}
+			}
+		}
+
+		merged[k] = patchVal
+	}
+
+	return merged
+}
+
+// mergeIgnitionList merges two ignition list fields (e.g. storage.files) by
+// mergeKey: a patch entry replaces any base entry sharing its key value, new
+// patch entries are appended, and base entries absent from patch are left
+// untouched.
+func mergeIgnitionList(base, patch []interface{}, mergeKey string) []interface{} {
+	patchedKeys := map[interface{}]bool{}
+	for _, p := range patch {
+		if pm, ok := p.(map[string]interface{}); ok {
+			patchedKeys[pm[mergeKey]] = true
+		}
+	}
+
+	merged := make([]interface{}, 0, len(base)+len(patch))
+	for _, b := range base {
+		if bm, ok := b.(map[string]interface{}); ok && patchedKeys[bm[mergeKey]] {
+			continue
+		}
+		merged = append(merged, b)
+	}
+
+	return append(merged, patch...)
+}
+
+// marshalMCToYaml converts mcJSON (a JSON-encoded MachineConfig) to its
+// trimmed YAML representation, the same pipeline MergeManifests uses for
+// the manifests it merges.
+func marshalMCToYaml(mcJSON []byte) (string, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(mcJSON, &m); err != nil {
+		return "", fmt.Errorf("could not convert json to map: (%s): %s", mcJSON, err)
+	}
+
+	d := yamltrim.YamlTrim(m)
+	if d == nil {
+		return "", fmt.Errorf("empty machineconfig")
+	}
+
+	yamlBytes, err := yaml.Marshal(d)
+	if err != nil {
+		return "", fmt.Errorf("could not convert map to yaml: (%s): %s", m, err)
+	}
+	return string(yamlBytes), nil
+}
+
+// applyStandalonePatches applies any patches targeting manifestYAML's role
+// directly to it, leaving non-MachineConfig manifests and roles with no
+// matching patch untouched. It's used for doNotMergeButPatch entries, which
+// stay out of the combined per-role MachineConfig but still need their own
+// patches applied. The returned role is the role whose patches were
+// consumed ("" if manifestYAML isn't a MachineConfig or had no matching
+// patches), so callers can tell which patchesByRole entries were used.
+func applyStandalonePatches(manifestYAML string, patchesByRole map[string][]rolePatch) (result string, role string, err error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal([]byte(manifestYAML), &data); err != nil {
+		return "", "", fmt.Errorf("could not unmarshal file content: %v", err)
+	}
+	if data["kind"] != mcKind {
+		return manifestYAML, "", nil
+	}
+
+	mc, err := convertToMC(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	mcRole := mc.ObjectMeta.Labels[machineconfigv1.MachineConfigRoleLabelKey]
+	patches := patchesByRole[mcRole]
+	if len(patches) == 0 {
+		return manifestYAML, "", nil
+	}
+
+	manifestJSON, err := json.Marshal(data)
+	if err != nil {
+		return "", "", fmt.Errorf("could not convert mc to json: %v", err)
+	}
+
+	patchedJSON, err := applyRolePatches(manifestJSON, patches)
+	if err != nil {
+		return "", "", err
+	}
+
+	patchedYAML, err := marshalMCToYaml(patchedJSON)
+	if err != nil {
+		return "", "", err
+	}
+	return patchedYAML, mcRole, nil
+}
+
+// merge the spec fields of all MC manifests except the ones that are in the doNotMerge list.
+// doNotMergeButPatch is optional and names entries that stay out of the merge
+// but still get any patch fragments targeting their role applied directly to
+// them; it's variadic so existing 2-arg callers keep compiling unchanged.
+func MergeManifests(individualMachineConfigs map[string]interface{}, doNotMerge map[string]bool, doNotMergeButPatch ...map[string]bool) (map[string]interface{}, error) {
+	var patchExclusions map[string]bool
+	if len(doNotMergeButPatch) > 0 {
+		patchExclusions = doNotMergeButPatch[0]
+	}
+
+	patchesByRole, err := collectRolePatches(individualMachineConfigs)
+	if err != nil {
+		return individualMachineConfigs, err
+	}
+
 	// key is role, value is a list of MCs
 	mergableMachineConfigs := make(map[string][]*machineconfigv1.MachineConfig)
+	// tracks which patchesByRole entries were actually applied somewhere, so a
+	// patch whose role matches neither a merged role nor a doNotMergeButPatch
+	// entry can be reported instead of silently discarded
+	appliedPatchRoles := map[string]bool{}
 
 	for filename, machineConfig := range individualMachineConfigs {
-		if doNotMerge[filename] {
+		if doNotMerge[filename] || patchExclusions[filename] {
+			if patchExclusions[filename] {
+				patched, patchedRole, err := applyStandalonePatches(machineConfig.(string), patchesByRole)
+				if err != nil {
+					return individualMachineConfigs, fmt.Errorf("could not patch %s: %v", filename, err)
+				}
+				individualMachineConfigs[filename] = patched
+				if patchedRole != "" {
+					appliedPatchRoles[patchedRole] = true
+				}
+			}
 			continue
 		}
 
@@ -141,6 +692,16 @@ func MergeManifests(individualMachineConfigs map[string]interface{}, doNotMerge
 			return nil, err
 		}
 
+		// MergeMachineConfigs always sets OSImageURL from cconfig.Spec.OSImageURL,
+		// which is empty here, so any OSImageURL carried by one of the input
+		// MachineConfigs (e.g. a synthesized 00-<role>-osimageurl MC) would
+		// otherwise be silently dropped from the merged result.
+		for _, mc := range machineConfigs {
+			if mc.Spec.OSImageURL != "" {
+				merged.Spec.OSImageURL = mc.Spec.OSImageURL
+			}
+		}
+
 		merged.SetName(fmt.Sprintf("%s-%s", McName, roleName))
 		merged.ObjectMeta.Labels = make(map[string]string)
 		merged.ObjectMeta.Labels[machineconfigv1.MachineConfigRoleLabelKey] = roleName
@@ -156,31 +717,156 @@ func MergeManifests(individualMachineConfigs map[string]interface{}, doNotMerge
 			return nil, err
 		}
 
-		var m map[string]interface{}
-		// Unmarshal the json string to interface for YamlTrim
-		err = json.Unmarshal(b, &m)
+		// Apply any user-authored patches targeting this role before trimming
+		// and re-serializing to YAML.
+		if len(patchesByRole[roleName]) > 0 {
+			appliedPatchRoles[roleName] = true
+		}
+		b, err = applyRolePatches(b, patchesByRole[roleName])
 		if err != nil {
-			log.Printf("Error: could not convert json to map: (%s): %s\n", b, err)
+			log.Printf("Error: could not apply patches for role (%s): %s\n", roleName, err)
 			return nil, err
 		}
 
-		d := yamltrim.YamlTrim(m)
-		if d == nil {
-			return nil, fmt.Errorf("empty machineconfig")
-		}
-		// Marshal the interface to yaml bytes
-		yamlBytes, err := yaml.Marshal(d)
+		yamlStr, err := marshalMCToYaml(b)
 		if err != nil {
-			log.Printf("Error: could not convert map to yaml: (%s): %s\n", m, err)
+			log.Printf("Error: %s\n", err)
 			return nil, err
 		}
 		fileName := fmt.Sprintf("%s.yaml", merged.ObjectMeta.Name)
-		individualMachineConfigs[fileName] = string(yamlBytes)
+		individualMachineConfigs[fileName] = yamlStr
+	}
+
+	for role := range patchesByRole {
+		if !appliedPatchRoles[role] {
+			return individualMachineConfigs, fmt.Errorf("patch targeting role %q matched no merged MachineConfig or doNotMergeButPatch entry", role)
+		}
 	}
 
 	return individualMachineConfigs, nil
 }
 
+// osImageURLControllerVersionAnnotation mirrors the annotation key the
+// Machine Config Operator sets on MachineConfigs it generates, so the
+// synthesized osImageURL MachineConfig has the shape the MCO expects.
+const osImageURLControllerVersionAnnotation = "machineconfiguration.openshift.io/generated-by-controller-version"
+
+// BuildOSImageURLMachineConfig synthesizes a `00-<role>-osimageurl`
+// MachineConfig manifest (as a YAML string) pinning spec.osImageURL to
+// osImageURL for role. The returned manifest is meant to be added to the
+// individualMachineConfigs map passed to MergeManifests so it merges with
+// any other MachineConfigs for that role via mcfgctrlcommon.MergeMachineConfigs.
+func BuildOSImageURLMachineConfig(role, osImageURL string) (string, error) {
+	mc := &machineconfigv1.MachineConfig{}
+	mc.SetName(fmt.Sprintf("00-%s-osimageurl", role))
+	mc.TypeMeta.APIVersion = machineconfigv1.GroupVersion.String()
+	mc.TypeMeta.Kind = mcKind
+	mc.ObjectMeta.Labels = map[string]string{
+		machineconfigv1.MachineConfigRoleLabelKey: role,
+	}
+	mc.ObjectMeta.Annotations = map[string]string{
+		osImageURLControllerVersionAnnotation: ZtpAnnotationDefaultValue,
+	}
+	mc.Spec.OSImageURL = osImageURL
+
+	b, err := json.Marshal(mc)
+	if err != nil {
+		return "", fmt.Errorf("could not convert osImageURL mc to json: %v", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", fmt.Errorf("could not convert json to map: %v", err)
+	}
+
+	d := yamltrim.YamlTrim(m)
+	if d == nil {
+		return "", fmt.Errorf("empty machineconfig")
+	}
+
+	yamlBytes, err := yaml.Marshal(d)
+	if err != nil {
+		return "", fmt.Errorf("could not convert map to yaml: %v", err)
+	}
+	return string(yamlBytes), nil
+}
+
+// AddOSImageURLMachineConfigs synthesizes a 00-<role>-osimageurl
+// MachineConfig for every role already present in individualMachineConfigs
+// (skipping entries in doNotMerge) and adds it to the map so MergeManifests
+// picks it up alongside any user-provided MachineConfigs for that role.
+// perRoleOSImageURL overrides osImageURL for specific roles; a role with no
+// entry there falls back to osImageURL. Roles with neither a per-role
+// override nor a non-empty osImageURL are left untouched, so ZTP clusters
+// that don't pin a bootimage see no behavior change.
+func AddOSImageURLMachineConfigs(individualMachineConfigs map[string]interface{}, doNotMerge map[string]bool, osImageURL string, perRoleOSImageURL map[string]string) error {
+	roles := map[string]bool{}
+
+	for filename, machineConfig := range individualMachineConfigs {
+		if doNotMerge[filename] {
+			continue
+		}
+
+		var data map[string]interface{}
+		if err := yaml.Unmarshal([]byte(machineConfig.(string)), &data); err != nil {
+			log.Printf("Error Could not unmarshal file content: (%s): %s\n", filename, err)
+			return err
+		}
+		if data["kind"] != mcKind {
+			continue
+		}
+
+		mc, err := convertToMC(data)
+		if err != nil {
+			return err
+		}
+		roles[mc.ObjectMeta.Labels[machineconfigv1.MachineConfigRoleLabelKey]] = true
+	}
+
+	for role := range roles {
+		url := osImageURL
+		if override, found := perRoleOSImageURL[role]; found {
+			url = override
+		}
+		if url == "" {
+			continue
+		}
+
+		manifest, err := BuildOSImageURLMachineConfig(role, url)
+		if err != nil {
+			return err
+		}
+		individualMachineConfigs[fmt.Sprintf("00-%s-osimageurl.yaml", role)] = manifest
+	}
+
+	return nil
+}
+
+// SynthesizeOSImageURLMachineConfigs reads clusterSpec.OSImageURL (the
+// cluster-wide pin) and clusterSpec.OSImageURLOverrides (a role ->
+// osImageURL override map, for e.g. a worker pool that needs a different
+// bootimage than master), and synthesizes the per-role osImageURL
+// MachineConfigs via AddOSImageURLMachineConfigs. It's called by
+// GenerateMCManifests before MergeManifests runs, so the synthesized MC is
+// merged in alongside the user's own.
+func SynthesizeOSImageURLMachineConfigs(clusterSpec Clusters, individualMachineConfigs map[string]interface{}, doNotMerge map[string]bool) error {
+	return AddOSImageURLMachineConfigs(individualMachineConfigs, doNotMerge, clusterSpec.OSImageURL, clusterSpec.OSImageURLOverrides)
+}
+
+// GenerateMCManifests is the manifest-generation entry point for a
+// cluster's MachineConfig manifests: it synthesizes any osImageURL
+// MachineConfigs pinned by clusterSpec via SynthesizeOSImageURLMachineConfigs,
+// then merges the result with MergeManifests. Manifest generation should
+// call this instead of calling MergeManifests directly, so an osImageURL
+// pin actually makes it into the generated manifests rather than being
+// silently ignored.
+func GenerateMCManifests(clusterSpec Clusters, individualMachineConfigs map[string]interface{}, doNotMerge map[string]bool, doNotMergeButPatch map[string]bool) (map[string]interface{}, error) {
+	if err := SynthesizeOSImageURLMachineConfigs(clusterSpec, individualMachineConfigs, doNotMerge); err != nil {
+		return individualMachineConfigs, err
+	}
+	return MergeManifests(individualMachineConfigs, doNotMerge, doNotMergeButPatch)
+}
+
 // convert yaml data to MC
 func convertToMC(data map[string]interface{}) (*machineconfigv1.MachineConfig, error) {
 	// Convert the yaml string to json
@@ -238,12 +924,23 @@ func addZTPAnnotation(data map[string]interface{}, extraAnnotations ...*annotati
 	}
 }
 
-// Add ztp deploy annotation to all siteconfig generated CRs
+// Add ztp deploy annotation to all siteconfig generated CRs. If any of
+// extraAnnotations recorded a SeverityError entry, that's surfaced as a hard
+// error instead of just an annotation, so a severity: error registry entry
+// (see LoadAnnotationMessages) actually fails generation rather than merely
+// decorating the output.
 func addZTPAnnotationToCRs(clusterCRs []interface{}, extraAnnotations ...*annotationWarning) ([]interface{}, error) {
 
 	for _, v := range clusterCRs {
 		addZTPAnnotation(v.(map[string]interface{}), extraAnnotations...)
 	}
+
+	for _, annotation := range extraAnnotations {
+		if annotation.HasErrors() {
+			return clusterCRs, fmt.Errorf("deprecation/warning registry reported error-severity findings: %s", strings.Join(annotation.ErrorMessages(), "; "))
+		}
+	}
+
 	return clusterCRs, nil
 }
 
@@ -279,100 +976,133 @@ func deleteInspectAnnotation(bmhCR map[string]interface{}) map[string]interface{
 // agentClusterInstallAnnotation returns string in json format
 func agentClusterInstallAnnotation(networkType, installConfigOverrides string) (string, error) {
 
-	var commonKey = "networking"
 	networkAnnotation := "{\"networking\":{\"networkType\":\"" + networkType + "\"}}"
 	if !json.Valid([]byte(networkAnnotation)) {
 		return "", fmt.Errorf("Invalid json conversion of network type")
 	}
 
-	switch installConfigOverrides {
-	case "":
-		return networkAnnotation, nil
-
-	default:
-		if !json.Valid([]byte(installConfigOverrides)) {
-			return "", fmt.Errorf("Invalid json parameter set at installConfigOverride")
-		}
-
-		var installConfigOverridesMap map[string]interface{}
-		err := json.Unmarshal([]byte(installConfigOverrides), &installConfigOverridesMap)
-		if err != nil {
-			return "", fmt.Errorf("Could not unmarshal installConfigOverrides data: %v\n", installConfigOverrides)
-		}
+	merged, err := MergeInstallConfigOverrides(networkAnnotation, installConfigOverrides, []string{"networkType"})
+	if err != nil {
+		return "", fmt.Errorf("Couldn't marshal annotation for AgentClusterInstall, Error: %v", err)
+	}
+	return merged, nil
+}
 
-		if _, found := installConfigOverridesMap[commonKey]; found {
-			networkMergedJson, err := mergeJsonCommonKey(networkAnnotation, installConfigOverrides, commonKey)
-			if err != nil {
-				return "", fmt.Errorf("Couldn't marshal annotation for AgentClusterInstall, Error: %v\n", err)
-			}
-			return networkMergedJson, nil
-		}
+// MergeInstallConfigOverrides recursively merges the siteconfig-generated
+// base document with the user-supplied installConfigOverrides document.
+// For every key present in both documents:
+//   - if the key appears in forceKeys, the siteconfig-generated (base) value
+//     wins, at any depth and whatever the types of the two values
+//   - otherwise, if both values are JSON objects, the merge recurses into them
+//   - otherwise, if both values are JSON arrays, the arrays are concatenated
+//     with duplicate (deep-equal) entries removed
+//   - otherwise the user-supplied value wins
+//
+// Keys present in only one of the two documents are copied through as-is.
+// Either base or user may be the empty string, in which case the other
+// document is returned unchanged.
+func MergeInstallConfigOverrides(base, user string, forceKeys []string) (string, error) {
+	baseMap, err := unmarshalJSONObject(base, "base")
+	if err != nil {
+		return "", err
+	}
 
-		trimmedConfigOverrides := strings.TrimPrefix(installConfigOverrides, "{")
-		trimmedNetworkType := strings.TrimSuffix(networkAnnotation, "}")
-		finalJson := trimmedNetworkType + "," + trimmedConfigOverrides
-		if !json.Valid([]byte(finalJson)) {
-			return "", fmt.Errorf("Couldn't marshal annotation for AgentClusterInstall")
-		}
-		return finalJson, nil
+	userMap, err := unmarshalJSONObject(user, "installConfigOverrides")
+	if err != nil {
+		return "", err
+	}
 
+	forceKeySet := make(map[string]bool, len(forceKeys))
+	for _, k := range forceKeys {
+		forceKeySet[k] = true
 	}
 
+	merged, err := json.Marshal(mergeJSONObjects(baseMap, userMap, forceKeySet))
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
 }
 
-// mergeJsonCommonKey merge 2 json in common key and return string
-func mergeJsonCommonKey(mergeWith, mergeTo, key string) (string, error) {
+// unmarshalJSONObject unmarshals a possibly-empty JSON object string,
+// returning an empty map for the empty string and an error identifying
+// which input was invalid otherwise.
+func unmarshalJSONObject(data, name string) (map[string]interface{}, error) {
+	if data == "" {
+		return map[string]interface{}{}, nil
+	}
 
-	var (
-		networkAnnotation      map[string]interface{}
-		installConfigOverrides map[string]interface{}
-	)
+	if !json.Valid([]byte(data)) {
+		return nil, fmt.Errorf("invalid json parameter set at %s", name)
+	}
 
-	// converted to map
-	err := json.Unmarshal([]byte(mergeWith), &networkAnnotation)
-	if err != nil {
-		return "", err
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s data: %v", name, err)
 	}
+	return m, nil
+}
 
-	// converted to map
-	err = json.Unmarshal([]byte(mergeTo), &installConfigOverrides)
-	if err != nil {
-		return "", err
+// mergeJSONObjects merges base into user per the rules documented on
+// MergeInstallConfigOverrides.
+func mergeJSONObjects(base, user map[string]interface{}, forceKeys map[string]bool) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(user))
+	for k, v := range user {
+		merged[k] = v
 	}
 
-	// reate a new map which will be passed to networking
-	// the size of the map can be anything but must be initialized
-	// otherwise it will panic
-	mergedValueMap := make(map[string]interface{}, len(installConfigOverrides))
+	for k, baseVal := range base {
+		userVal, found := merged[k]
+		if !found {
+			merged[k] = baseVal
+			continue
+		}
+
+		if forceKeys[k] {
+			merged[k] = baseVal
+			continue
+		}
 
-	// append value to the new map
-	if value, found := installConfigOverrides[key]; found {
-		anothernConfig := value.(map[string]interface{})
-		for i, v := range anothernConfig {
-			mergedValueMap[i] = v
+		if baseObj, ok := baseVal.(map[string]interface{}); ok {
+			if userObj, ok := userVal.(map[string]interface{}); ok {
+				merged[k] = mergeJSONObjects(baseObj, userObj, forceKeys)
+				continue
+			}
 		}
-	}
 
-	// append the value to the new map
-	// additionally if user passed a wrong value for
-	// networkType as "networkType":"default", it will be
-	// overwritten with correct value
-	if value, found := networkAnnotation[key]; found {
-		value := value.(map[string]interface{})
-		for i, v := range value {
-			mergedValueMap[i] = v
+		if baseArr, ok := baseVal.([]interface{}); ok {
+			if userArr, ok := userVal.([]interface{}); ok {
+				merged[k] = mergeJSONArrays(baseArr, userArr)
+				continue
+			}
 		}
+
+		// type mismatch or scalar on both sides: the user override wins
 	}
 
-	// set networking field to the new map
-	installConfigOverrides[key] = mergedValueMap
+	return merged
+}
 
-	// build new json and return as string
-	newJson, err := json.Marshal(installConfigOverrides)
-	if err != nil {
-		return "", err
+// mergeJSONArrays concatenates user and base, dropping any base entry that
+// is deep-equal to an entry already present in user.
+func mergeJSONArrays(base, user []interface{}) []interface{} {
+	merged := make([]interface{}, len(user), len(user)+len(base))
+	copy(merged, user)
+
+	for _, v := range base {
+		duplicate := false
+		for _, existing := range merged {
+			if reflect.DeepEqual(v, existing) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, v)
+		}
 	}
-	return string(newJson), nil
+
+	return merged
 }
 
 func applyWorkloadPinningInstallConfigOverrides(clusterSpec *Clusters) (result string, err error) {
@@ -381,24 +1111,15 @@ func applyWorkloadPinningInstallConfigOverrides(clusterSpec *Clusters) (result s
 	)
 
 	if clusterSpec.CPUPartitioning == CPUPartitioningAllNodes {
-		installOverrideValues := map[string]interface{}{}
-		if clusterSpec.InstallConfigOverrides != "" {
-			err := json.Unmarshal([]byte(clusterSpec.InstallConfigOverrides), &installOverrideValues)
-			if err != nil {
-				fmt.Println("err", err)
-				return clusterSpec.InstallConfigOverrides, err
-			}
-		}
-
 		// Because the explicit value clusterSpec.CPUPartitioning == CPUPartitioningAllNodes, we always overwrite
 		// the installConfigOverrides value or add it if not present
-		installOverrideValues[cpuPartitioningKey] = CPUPartitioningAllNodes
+		cpuPartitioningAnnotation := fmt.Sprintf("{%q:%q}", cpuPartitioningKey, CPUPartitioningAllNodes)
 
-		byteData, err := json.Marshal(installOverrideValues)
+		merged, err := MergeInstallConfigOverrides(cpuPartitioningAnnotation, clusterSpec.InstallConfigOverrides, []string{cpuPartitioningKey})
 		if err != nil {
 			return clusterSpec.InstallConfigOverrides, err
 		}
-		return string(byteData), nil
+		return merged, nil
 	}
 
 	return clusterSpec.InstallConfigOverrides, nil
@@ -408,9 +1129,15 @@ func applyWorkloadPinningInstallConfigOverrides(clusterSpec *Clusters) (result s
 func getDeprecationWarnings(clusterSpec Clusters) *annotationWarning {
 	deprecationWarnings := NewAnnotationWarning(ZtpDeprecationWarningAnnotationPostfix)
 
-	for _, field := range annotationMessages {
+	messages, err := LoadAnnotationMessages("")
+	if err != nil {
+		log.Printf("Error: could not load deprecation warnings registry, falling back to built-in messages: %v\n", err)
+		messages = annotationMessages
+	}
+
+	for _, field := range messages {
 		if field.ShouldBeApplied != nil && field.ShouldBeApplied(clusterSpec) {
-			deprecationWarnings.Add(field.CRName, field.annotationValue.fieldName, field.annotationValue.fieldMessage)
+			deprecationWarnings.Add(field.CRName, field.annotationValue.fieldName, field.annotationValue.fieldMessage, field.annotationValue.severity)
 		}
 	}
 	return deprecationWarnings
@@ -0,0 +1,527 @@
+package siteConfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	machineconfigv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestMergeInstallConfigOverrides_Networking(t *testing.T) {
+	base := `{"networking":{"networkType":"OVNKubernetes"}}`
+	user := `{"networking":{"networkType":"wrong","clusterNetwork":[{"cidr":"10.128.0.0/14"}]}}`
+
+	merged, err := MergeInstallConfigOverrides(base, user, []string{"networkType"})
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(merged), &got))
+
+	networking := got["networking"].(map[string]interface{})
+	assert.Equal(t, "OVNKubernetes", networking["networkType"])
+	assert.Len(t, networking["clusterNetwork"], 1)
+}
+
+func TestMergeInstallConfigOverrides_AdditionalTrustBundle(t *testing.T) {
+	base := `{"networking":{"networkType":"OVNKubernetes"}}`
+	user := `{"additionalTrustBundle":"-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"}`
+
+	merged, err := MergeInstallConfigOverrides(base, user, []string{"networkType"})
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(merged), &got))
+
+	assert.Contains(t, got["additionalTrustBundle"], "BEGIN CERTIFICATE")
+	networking := got["networking"].(map[string]interface{})
+	assert.Equal(t, "OVNKubernetes", networking["networkType"])
+}
+
+func TestMergeInstallConfigOverrides_Proxy(t *testing.T) {
+	base := `{"networking":{"networkType":"OVNKubernetes"}}`
+	user := `{"proxy":{"httpProxy":"http://proxy.example.com:8080","httpsProxy":"http://proxy.example.com:8080"}}`
+
+	merged, err := MergeInstallConfigOverrides(base, user, []string{"networkType"})
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(merged), &got))
+
+	proxy := got["proxy"].(map[string]interface{})
+	assert.Equal(t, "http://proxy.example.com:8080", proxy["httpProxy"])
+}
+
+func TestMergeInstallConfigOverrides_NestedPlatformBaremetal(t *testing.T) {
+	base := `{"platform":{"baremetal":{"apiVIPs":["192.168.1.10"],"ingressVIPs":["192.168.1.11"]}}}`
+	user := `{"platform":{"baremetal":{"apiVIPs":["192.168.1.10"],"hosts":[{"name":"worker-0"}]}}}`
+
+	merged, err := MergeInstallConfigOverrides(base, user, nil)
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(merged), &got))
+
+	baremetal := got["platform"].(map[string]interface{})["baremetal"].(map[string]interface{})
+	assert.Len(t, baremetal["apiVIPs"], 1, "duplicate apiVIPs entries should be deduped")
+	assert.Len(t, baremetal["ingressVIPs"], 1)
+	assert.Len(t, baremetal["hosts"], 1)
+}
+
+func TestMergeInstallConfigOverrides_ForceKeyWins(t *testing.T) {
+	base := `{"cpuPartitioningMode":"AllNodes"}`
+	user := `{"cpuPartitioningMode":"None","fips":true}`
+
+	merged, err := MergeInstallConfigOverrides(base, user, []string{"cpuPartitioningMode"})
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(merged), &got))
+
+	assert.Equal(t, "AllNodes", got["cpuPartitioningMode"])
+	assert.Equal(t, true, got["fips"])
+}
+
+func TestMergeInstallConfigOverrides_EmptyUser(t *testing.T) {
+	base := `{"networking":{"networkType":"OVNKubernetes"}}`
+
+	merged, err := MergeInstallConfigOverrides(base, "", []string{"networkType"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, base, merged)
+}
+
+func TestMergeInstallConfigOverrides_InvalidJSON(t *testing.T) {
+	_, err := MergeInstallConfigOverrides(`{"networking":`, "{}", nil)
+	assert.Error(t, err)
+
+	_, err = MergeInstallConfigOverrides("{}", `{"networking":`, nil)
+	assert.Error(t, err)
+}
+
+func TestBuildOSImageURLMachineConfig(t *testing.T) {
+	manifest, err := BuildOSImageURLMachineConfig("master", "registry.example.com/os-image:4.14")
+	assert.NoError(t, err)
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(manifest), &data))
+
+	assert.Equal(t, "00-master-osimageurl", data["metadata"].(map[string]interface{})["name"])
+	labels := data["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+	assert.Equal(t, "master", labels[machineconfigv1.MachineConfigRoleLabelKey])
+	spec := data["spec"].(map[string]interface{})
+	assert.Equal(t, "registry.example.com/os-image:4.14", spec["osImageURL"])
+}
+
+func TestAddOSImageURLMachineConfigs(t *testing.T) {
+	masterMC := `
+kind: MachineConfig
+metadata:
+  name: custom-master
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config: {}
+`
+	individualMachineConfigs := map[string]interface{}{
+		"custom-master.yaml": masterMC,
+		"skip-me.yaml":       masterMC,
+	}
+	doNotMerge := map[string]bool{"skip-me.yaml": true}
+
+	err := AddOSImageURLMachineConfigs(individualMachineConfigs, doNotMerge, "registry.example.com/default:4.14", map[string]string{
+		"master": "registry.example.com/master-override:4.14",
+	})
+	assert.NoError(t, err)
+
+	generated, found := individualMachineConfigs["00-master-osimageurl.yaml"]
+	assert.True(t, found)
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(generated.(string)), &data))
+	assert.Equal(t, "registry.example.com/master-override:4.14", data["spec"].(map[string]interface{})["osImageURL"])
+
+	_, workerGenerated := individualMachineConfigs["00-worker-osimageurl.yaml"]
+	assert.False(t, workerGenerated, "no worker MC was present so no worker osImageURL MC should be synthesized")
+}
+
+func TestMergeManifests_PreservesOSImageURLAfterMerge(t *testing.T) {
+	masterMC := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  name: custom-master
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+`
+	individualMachineConfigs := map[string]interface{}{
+		"custom-master.yaml": masterMC,
+	}
+
+	err := AddOSImageURLMachineConfigs(individualMachineConfigs, map[string]bool{}, "registry.example.com/pinned:4.14", nil)
+	assert.NoError(t, err)
+
+	result, err := MergeManifests(individualMachineConfigs, map[string]bool{}, map[string]bool{})
+	assert.NoError(t, err)
+
+	merged, found := result["predefined-extra-manifests-master.yaml"]
+	assert.True(t, found)
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(merged.(string)), &data))
+	assert.Equal(t, "registry.example.com/pinned:4.14", data["spec"].(map[string]interface{})["osImageURL"],
+		"osImageURL must survive mcfgctrlcommon.MergeMachineConfigs, which otherwise zeroes it from an empty ControllerConfig")
+}
+
+func TestGenerateMCManifests_SynthesizesAndMergesOSImageURL(t *testing.T) {
+	masterMC := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  name: custom-master
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+`
+	individualMachineConfigs := map[string]interface{}{
+		"custom-master.yaml": masterMC,
+	}
+	clusterSpec := Clusters{OSImageURL: "registry.example.com/pinned:4.14"}
+
+	result, err := GenerateMCManifests(clusterSpec, individualMachineConfigs, map[string]bool{}, map[string]bool{})
+	assert.NoError(t, err)
+
+	merged, found := result["predefined-extra-manifests-master.yaml"]
+	assert.True(t, found)
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(merged.(string)), &data))
+	assert.Equal(t, "registry.example.com/pinned:4.14", data["spec"].(map[string]interface{})["osImageURL"],
+		"GenerateMCManifests must synthesize the osImageURL MC and carry it through the merge")
+}
+
+func TestLoadAnnotationMessages_FallsBackWhenFileMissing(t *testing.T) {
+	messages, err := LoadAnnotationMessages(filepath.Join(t.TempDir(), "does-not-exist.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, annotationMessages, messages)
+}
+
+func TestLoadAnnotationMessages_LoadsRegistryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deprecation-warnings.yaml")
+	contents := `
+- crName: AgentClusterInstall
+  fieldName: cpuset
+  message: cpuset is deprecated, use cpuPartitioningMode
+  severity: error
+  when: "has(cluster.nodes) && cluster.nodes.exists(n, n.cpuset != '')"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	messages, err := LoadAnnotationMessages(path)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "AgentClusterInstall", messages[0].CRName)
+	assert.Equal(t, "cpuset", messages[0].annotationValue.fieldName)
+	assert.Equal(t, SeverityError, messages[0].annotationValue.severity)
+}
+
+func TestLoadAnnotationMessages_DefaultsSeverityToWarning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deprecation-warnings.yaml")
+	contents := `
+- crName: ConfigMap
+  fieldName: extraManifestPath
+  message: deprecated
+  when: "true"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	messages, err := LoadAnnotationMessages(path)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, SeverityWarning, messages[0].annotationValue.severity)
+}
+
+func TestLoadAnnotationMessages_RejectsNonBoolExpression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deprecation-warnings.yaml")
+	contents := `
+- crName: ConfigMap
+  fieldName: extraManifestPath
+  message: deprecated
+  when: "cluster.nodes"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	_, err := LoadAnnotationMessages(path)
+	assert.Error(t, err)
+}
+
+func TestLoadAnnotationMessages_InvalidExpressionReportsSourceLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deprecation-warnings.yaml")
+	contents := `
+- crName: ConfigMap
+  fieldName: extraManifestPath
+  message: deprecated
+  when: "true"
+- crName: AgentClusterInstall
+  fieldName: cpuset
+  message: deprecated
+  when: "cluster.nodes"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+
+	_, err := LoadAnnotationMessages(path)
+	assert.Error(t, err)
+	assert.Regexp(t, regexp.MustCompile(fmt.Sprintf(`^%s:\d+:`, regexp.QuoteMeta(path))), err.Error(),
+		"error should point at the source line of the offending entry, not just its index")
+}
+
+func TestAddZTPAnnotationToCRs_ErrorSeverityFailsGeneration(t *testing.T) {
+	w := NewAnnotationWarning("test")
+	w.Add("ConfigMap", "cpuset", "cpuset is no longer supported", SeverityError)
+
+	_, err := addZTPAnnotationToCRs([]interface{}{
+		map[string]interface{}{"kind": "ConfigMap"},
+	}, w)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cpuset is no longer supported")
+}
+
+func TestAddZTPAnnotationToCRs_WarningSeverityDoesNotFailGeneration(t *testing.T) {
+	w := NewAnnotationWarning("test")
+	w.Add("ConfigMap", "extraManifestPath", "deprecated", SeverityWarning)
+
+	_, err := addZTPAnnotationToCRs([]interface{}{
+		map[string]interface{}{"kind": "ConfigMap"},
+	}, w)
+	assert.NoError(t, err)
+}
+
+func TestCompileCelExpression_CachesProgram(t *testing.T) {
+	expr := "cluster.clusterName == 'foo'"
+
+	prg1, err := compileCelExpression(expr)
+	assert.NoError(t, err)
+	assert.NotNil(t, prg1)
+
+	prg2, err := compileCelExpression(expr)
+	assert.NoError(t, err)
+	assert.NotNil(t, prg2)
+
+	cached, found := celProgCache.Load(expr)
+	assert.True(t, found)
+	assert.Equal(t, prg1, cached)
+}
+
+func TestAnnotationWarning_HasErrors(t *testing.T) {
+	w := NewAnnotationWarning("test")
+	assert.False(t, w.HasErrors())
+
+	w.Add("ConfigMap", "extraManifestPath", "deprecated", SeverityWarning)
+	assert.False(t, w.HasErrors())
+
+	w.Add("AgentClusterInstall", "cpuset", "deprecated", SeverityError)
+	assert.True(t, w.HasErrors())
+}
+
+func TestMergeManifests_StrategicMergePatchMergesFilesByPath(t *testing.T) {
+	masterMC := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  name: 99-master-chrony
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+    storage:
+      files:
+      - path: /etc/chrony.conf
+        mode: 420
+        contents:
+          source: data:,original
+      - path: /etc/base-only.conf
+        mode: 420
+        contents:
+          source: data:,base-only
+`
+	patch := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+    storage:
+      files:
+      - path: /etc/chrony.conf
+        mode: 420
+        contents:
+          source: data:,patched
+      - path: /etc/extra.conf
+        mode: 420
+        contents:
+          source: data:,extra
+`
+	individualMachineConfigs := map[string]interface{}{
+		"99-master-chrony.yaml": masterMC,
+		"master.smp.yaml":       patch,
+	}
+
+	result, err := MergeManifests(individualMachineConfigs, map[string]bool{}, map[string]bool{})
+	assert.NoError(t, err)
+
+	merged, found := result["predefined-extra-manifests-master.yaml"]
+	assert.True(t, found)
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(merged.(string)), &data))
+
+	files := data["spec"].(map[string]interface{})["config"].(map[string]interface{})["storage"].(map[string]interface{})["files"].([]interface{})
+	assert.Len(t, files, 3, "files should be merged by path, not replaced wholesale")
+
+	byPath := map[string]interface{}{}
+	for _, f := range files {
+		fm := f.(map[string]interface{})
+		byPath[fm["path"].(string)] = fm["contents"].(map[string]interface{})["source"]
+	}
+	assert.Equal(t, "data:,patched", byPath["/etc/chrony.conf"])
+	assert.Equal(t, "data:,extra", byPath["/etc/extra.conf"])
+	assert.Equal(t, "data:,base-only", byPath["/etc/base-only.conf"], "a base-only file absent from the patch must survive the merge")
+}
+
+func TestMergeManifests_JSONMergePatchOverridesKernelArguments(t *testing.T) {
+	workerMC := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  name: 99-worker-kargs
+  labels:
+    machineconfiguration.openshift.io/role: worker
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+  kernelArguments:
+  - nosmt
+`
+	patch := `
+{"spec":{"kernelArguments":["nosmt","intel_iommu=on"]}}
+`
+	individualMachineConfigs := map[string]interface{}{
+		"99-worker-kargs.yaml": workerMC,
+		"worker.jmp.yaml":      patch,
+	}
+
+	result, err := MergeManifests(individualMachineConfigs, map[string]bool{}, map[string]bool{})
+	assert.NoError(t, err)
+
+	merged, found := result["predefined-extra-manifests-worker.yaml"]
+	assert.True(t, found)
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(merged.(string)), &data))
+
+	kargs := data["spec"].(map[string]interface{})["kernelArguments"].([]interface{})
+	assert.ElementsMatch(t, []interface{}{"nosmt", "intel_iommu=on"}, kargs)
+}
+
+func TestMergeManifests_DoNotMergeButPatch(t *testing.T) {
+	standaloneMC := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  name: 99-master-standalone
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+  kernelArguments:
+  - nosmt
+`
+	patch := `{"spec":{"kernelArguments":["nosmt","amd_iommu=on"]}}`
+
+	individualMachineConfigs := map[string]interface{}{
+		"99-master-standalone.yaml": standaloneMC,
+		"master.jmp.yaml":           patch,
+	}
+	doNotMerge := map[string]bool{"99-master-standalone.yaml": true}
+	doNotMergeButPatch := map[string]bool{"99-master-standalone.yaml": true}
+
+	result, err := MergeManifests(individualMachineConfigs, doNotMerge, doNotMergeButPatch)
+	assert.NoError(t, err)
+
+	patched, found := result["99-master-standalone.yaml"]
+	assert.True(t, found, "doNotMerge entries should keep their own filename")
+
+	_, mergedExists := result["predefined-extra-manifests-master.yaml"]
+	assert.False(t, mergedExists, "doNotMerge entries should not be folded into the merged role MC")
+
+	var data map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte(patched.(string)), &data))
+	kargs := data["spec"].(map[string]interface{})["kernelArguments"].([]interface{})
+	assert.ElementsMatch(t, []interface{}{"nosmt", "amd_iommu=on"}, kargs)
+}
+
+func TestMergeManifests_ErrorsOnPatchWithNoMatchingTarget(t *testing.T) {
+	masterMC := `
+apiVersion: machineconfiguration.openshift.io/v1
+kind: MachineConfig
+metadata:
+  name: 99-master-chrony
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config:
+    ignition:
+      version: 3.2.0
+`
+	// Typo'd role in the patch filename: no "infra" role MC exists, merged or standalone.
+	patch := `{"spec":{"kernelArguments":["nosmt"]}}`
+
+	individualMachineConfigs := map[string]interface{}{
+		"99-master-chrony.yaml": masterMC,
+		"infra.jmp.yaml":        patch,
+	}
+
+	_, err := MergeManifests(individualMachineConfigs, map[string]bool{}, map[string]bool{})
+	assert.Error(t, err, "a patch targeting a role with no matching MachineConfig should be reported, not silently dropped")
+}
+
+func TestMergeManifests_TwoArgCallStillWorks(t *testing.T) {
+	masterMC := `
+kind: MachineConfig
+metadata:
+  name: custom-master
+  labels:
+    machineconfiguration.openshift.io/role: master
+spec:
+  config: {}
+`
+	individualMachineConfigs := map[string]interface{}{
+		"custom-master.yaml": masterMC,
+	}
+
+	result, err := MergeManifests(individualMachineConfigs, map[string]bool{})
+	assert.NoError(t, err, "doNotMergeButPatch must be optional so pre-existing 2-arg callers keep compiling")
+
+	_, found := result["predefined-extra-manifests-master.yaml"]
+	assert.True(t, found)
+}